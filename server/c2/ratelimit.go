@@ -0,0 +1,279 @@
+package c2
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultRateLimit - Requests per second allowed for a single (remote IP, route) pair
+	DefaultRateLimit = 5.0
+	// DefaultRateBurst - Token bucket burst size
+	DefaultRateBurst = 10
+	// DefaultTarpitAfter - Consecutive rejections before we add a second, escalating delay
+	DefaultTarpitAfter = 3
+	// DefaultBanAfter - Consecutive rejections before the remote IP is temporarily blocklisted
+	DefaultBanAfter = 20
+
+	// banDuration - How long an IP stays blocklisted after tripping BanAfter
+	banDuration = 15 * time.Minute
+	// bucketIdleTTL - How long an idle token bucket is kept around before eviction
+	bucketIdleTTL = 10 * time.Minute
+)
+
+// rateLimiterMetrics - Prometheus-style counters, exposed via the existing logger.
+// Names follow the usual "<subsystem>_<noun>_total" convention so they can be
+// scraped/grepped out of the access log without pulling in a metrics client.
+type rateLimiterMetrics struct {
+	httpRequestsLimitedTotal int64
+	httpRequestsTarpitTotal  int64
+	httpRequestsBannedTotal  int64
+}
+
+func (m *rateLimiterMetrics) Snapshot() map[string]int64 {
+	return map[string]int64{
+		"sliver_http_requests_limited_total": atomic.LoadInt64(&m.httpRequestsLimitedTotal),
+		"sliver_http_requests_tarpit_total":  atomic.LoadInt64(&m.httpRequestsTarpitTotal),
+		"sliver_http_requests_banned_total":  atomic.LoadInt64(&m.httpRequestsBannedTotal),
+	}
+}
+
+// tokenBucket - Simple token bucket, refilled lazily on access
+type tokenBucket struct {
+	mutex      sync.Mutex
+	tokens     float64
+	lastRefill time.Time
+	lastSeen   time.Time
+	rejections int
+}
+
+func (b *tokenBucket) Allow(rate float64, burst int) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.lastRefill = now
+	b.lastSeen = now
+	b.tokens += elapsed * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	if b.tokens < 1 {
+		b.rejections++
+		return false
+	}
+	b.tokens--
+	b.rejections = 0
+	return true
+}
+
+// rateLimiter - Tracks token buckets keyed by (remote IP, route pattern) and a
+// temporary blocklist of IPs that have exceeded BanAfter consecutive rejections.
+type rateLimiter struct {
+	conf *HTTPServerConfig
+
+	bucketsMutex sync.Mutex
+	buckets      map[string]*tokenBucket
+
+	blocklistMutex sync.Mutex
+	blocklist      map[string]time.Time
+
+	metrics rateLimiterMetrics
+}
+
+func newRateLimiter(conf *HTTPServerConfig) *rateLimiter {
+	limiter := &rateLimiter{
+		conf:      conf,
+		buckets:   map[string]*tokenBucket{},
+		blocklist: map[string]time.Time{},
+	}
+	go limiter.evictIdleBuckets()
+	return limiter
+}
+
+func (r *rateLimiter) evictIdleBuckets() {
+	for {
+		time.Sleep(bucketIdleTTL)
+		r.bucketsMutex.Lock()
+		for key, bucket := range r.buckets {
+			bucket.mutex.Lock()
+			idle := time.Since(bucket.lastSeen) > bucketIdleTTL
+			bucket.mutex.Unlock()
+			if idle {
+				delete(r.buckets, key)
+			}
+		}
+		r.bucketsMutex.Unlock()
+	}
+}
+
+// clientIP - Client IP address to key the limiter/ban-list on, distinct from
+// the logging-oriented getRemoteAddr (which returns "IP:port" or a
+// "tcp(...)->ip" string). Without this, a scanner that opens a fresh TCP
+// connection per request -- trivial, and exactly the adversary this feature
+// targets -- would get a fresh ephemeral port, and therefore a fresh bucket
+// and rejection counter, on every single request and never be limited.
+func clientIP(req *http.Request) string {
+	if ipAddress := req.Header.Get("X-Real-Ip"); ipAddress != "" {
+		if ip := net.ParseIP(ipAddress); ip != nil {
+			return ip.String()
+		}
+	}
+	if ipAddress := req.Header.Get("X-Forwarded-For"); ipAddress != "" {
+		if ip := net.ParseIP(ipAddress); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (r *rateLimiter) bucketKey(remoteIP string, routePattern string) string {
+	return remoteIP + "|" + routePattern
+}
+
+func (r *rateLimiter) getBucket(remoteIP string, routePattern string) *tokenBucket {
+	key := r.bucketKey(remoteIP, routePattern)
+	r.bucketsMutex.Lock()
+	defer r.bucketsMutex.Unlock()
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(r.rateBurst()), lastRefill: time.Now(), lastSeen: time.Now()}
+		r.buckets[key] = bucket
+	}
+	return bucket
+}
+
+func (r *rateLimiter) rateLimit() float64 {
+	if r.conf.RateLimit <= 0 {
+		return DefaultRateLimit
+	}
+	return r.conf.RateLimit
+}
+
+func (r *rateLimiter) rateBurst() int {
+	if r.conf.RateBurst <= 0 {
+		return DefaultRateBurst
+	}
+	return r.conf.RateBurst
+}
+
+func (r *rateLimiter) tarpitAfter() int {
+	if r.conf.TarpitAfter <= 0 {
+		return DefaultTarpitAfter
+	}
+	return r.conf.TarpitAfter
+}
+
+func (r *rateLimiter) banAfter() int {
+	if r.conf.BanAfter <= 0 {
+		return DefaultBanAfter
+	}
+	return r.conf.BanAfter
+}
+
+// isBanned - Checks (and lazily expires) the temporary blocklist
+func (r *rateLimiter) isBanned(remoteIP string) bool {
+	r.blocklistMutex.Lock()
+	defer r.blocklistMutex.Unlock()
+	expiresAt, ok := r.blocklist[remoteIP]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiresAt) {
+		delete(r.blocklist, remoteIP)
+		return false
+	}
+	return true
+}
+
+func (r *rateLimiter) ban(remoteIP string) {
+	r.blocklistMutex.Lock()
+	defer r.blocklistMutex.Unlock()
+	r.blocklist[remoteIP] = time.Now().Add(banDuration)
+}
+
+// tarpitDelay - Draws from the same jitter distribution as getPollTimeout so a
+// rate-limited response is not distinguishable from normal network jitter.
+func (s *SliverHTTPC2) tarpitDelay() time.Duration {
+	return s.getPollTimeout()
+}
+
+// rateLimitMiddleware - Installed alongside loggingMiddleware/DefaultRespHeaders.
+// Rejections always fall through to default404Handler's exact output so a
+// rate-limited path and a nonexistent path are indistinguishable.
+func (s *SliverHTTPC2) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		remoteIP := clientIP(req)
+		route := mux.CurrentRoute(req)
+		routePattern := ""
+		if route != nil {
+			routePattern, _ = route.GetPathTemplate()
+		}
+
+		if s.rateLimiter.isBanned(remoteIP) {
+			atomic.AddInt64(&s.rateLimiter.metrics.httpRequestsBannedTotal, 1)
+			default404Handler(resp, req)
+			return
+		}
+
+		bucket := s.rateLimiter.getBucket(remoteIP, routePattern)
+		if bucket.Allow(s.rateLimiter.rateLimit(), s.rateLimiter.rateBurst()) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		atomic.AddInt64(&s.rateLimiter.metrics.httpRequestsLimitedTotal, 1)
+		bucket.mutex.Lock()
+		rejections := bucket.rejections
+		bucket.mutex.Unlock()
+
+		// Every rejection is delayed, not just ones past tarpitAfter(): an instant
+		// 404 for the first few rejections would itself be a timing side-channel
+		// distinguishing "rate-limited" from "really 404". TarpitAfter instead
+		// escalates an already-jittered client into a second, additional delay.
+		atomic.AddInt64(&s.rateLimiter.metrics.httpRequestsTarpitTotal, 1)
+		time.Sleep(s.tarpitDelay())
+		if rejections >= s.rateLimiter.tarpitAfter() {
+			time.Sleep(s.tarpitDelay())
+		}
+		if rejections >= s.rateLimiter.banAfter() {
+			httpLog.Warnf("Banning %s after %d consecutive rejections", remoteIP, rejections)
+			s.rateLimiter.ban(remoteIP)
+		}
+		httpLog.Debugf("rate limiter metrics: %v", s.rateLimiter.metrics.Snapshot())
+		default404Handler(resp, req)
+	})
+}
+
+// filterBlocklist - MatcherFunc companion to filterNonce, rejects banned IPs
+// before any handler-specific logic (OTP, nonce, etc.) ever runs.
+func (s *SliverHTTPC2) filterBlocklist(req *http.Request, rm *mux.RouteMatch) bool {
+	return !s.rateLimiter.isBanned(clientIP(req))
+}
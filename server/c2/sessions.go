@@ -0,0 +1,235 @@
+package c2
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/bishopfox/sliver/protobuf/sliverpb"
+	"github.com/bishopfox/sliver/server/core"
+	"github.com/bishopfox/sliver/server/cryptography"
+)
+
+// digestCiphertext - Hashes a ciphertext so replay state never has to retain
+// the plaintext/ciphertext itself, just enough to recognize a repeat.
+func digestCiphertext(ciphertext []byte) string {
+	if len(ciphertext) < 1 {
+		return ""
+	}
+	sha := sha256.New()
+	sha.Write(ciphertext)
+	return base64.RawStdEncoding.EncodeToString(sha.Sum(nil))
+}
+
+// DefaultReplayRingSize - Bounded number of replay digests retained per session
+const DefaultReplayRingSize = 4096
+
+// DefaultSessionTTL - How long an HTTP session may sit idle before the background
+// sweep expires it. Zero on HTTPServerConfig falls back to this value.
+const DefaultSessionTTL = 1 * time.Hour
+
+// SessionStore - Backing store for HTTPSession state, implemented by both an
+// in-memory map (memorySessionStore) and a BoltDB-backed store (boltSessionStore)
+// so a listener restart doesn't force every implant to re-key via startSessionHandler.
+type SessionStore interface {
+	Add(session *HTTPSession)
+	Get(sessionID string) *HTTPSession
+	Remove(sessionID string)
+	List() []*HTTPSession
+	Range(fn func(session *HTTPSession) bool)
+
+	// RecordReplay - Atomically records ciphertext digest into sessionID's replay
+	// ring and reports whether it had already been seen (i.e. a replay attack).
+	RecordReplay(sessionID string, digest string) bool
+
+	Close() error
+}
+
+// HTTPSession - Holds data related to a sliver c2 session
+type HTTPSession struct {
+	ID      string
+	Session *core.Session
+	Key     cryptography.AESKey
+	Started time.Time
+
+	mutex      sync.Mutex
+	replayRing []string
+	replaySeen map[string]bool
+}
+
+// isReplay - Records digest into the session's bounded replay ring, evicting the
+// oldest entry once DefaultReplayRingSize is exceeded, and reports whether it had
+// already been recorded.
+func (s *HTTPSession) isReplay(digest string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.replaySeen == nil {
+		s.replaySeen = map[string]bool{}
+	}
+	if s.replaySeen[digest] {
+		return true
+	}
+	s.replaySeen[digest] = true
+	s.replayRing = append(s.replayRing, digest)
+	if len(s.replayRing) > DefaultReplayRingSize {
+		oldest := s.replayRing[0]
+		s.replayRing = s.replayRing[1:]
+		delete(s.replaySeen, oldest)
+	}
+	return false
+}
+
+// memorySessionStore - The original in-memory only implementation of SessionStore
+type memorySessionStore struct {
+	active map[string]*HTTPSession
+	mutex  sync.RWMutex
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{active: map[string]*HTTPSession{}}
+}
+
+// Add - Add an HTTP session
+func (s *memorySessionStore) Add(session *HTTPSession) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.active[session.ID] = session
+}
+
+// Get - Get an HTTP session
+func (s *memorySessionStore) Get(sessionID string) *HTTPSession {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.active[sessionID]
+}
+
+// Remove - Remove an HTTP session
+func (s *memorySessionStore) Remove(sessionID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.active, sessionID)
+}
+
+// List - Snapshot of all currently held sessions
+func (s *memorySessionStore) List() []*HTTPSession {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	sessions := make([]*HTTPSession, 0, len(s.active))
+	for _, session := range s.active {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Range - Iterate over all sessions, stops early if fn returns false
+func (s *memorySessionStore) Range(fn func(session *HTTPSession) bool) {
+	for _, session := range s.List() {
+		if !fn(session) {
+			return
+		}
+	}
+}
+
+// RecordReplay - In-memory stores keep the replay ring on the HTTPSession itself
+func (s *memorySessionStore) RecordReplay(sessionID string, digest string) bool {
+	session := s.Get(sessionID)
+	if session == nil {
+		return false
+	}
+	return session.isReplay(digest)
+}
+
+// Close - No persistent resources to release
+func (s *memorySessionStore) Close() error {
+	return nil
+}
+
+// expireIdleSessions - Background sweep shared by every SessionStore implementation;
+// removes sessions whose core.Session has not checked in within ttl.
+func expireIdleSessions(store SessionStore, ttl time.Duration, stop <-chan struct{}) {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	ticker := time.NewTicker(ttl / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			store.Range(func(session *HTTPSession) bool {
+				if session.Session == nil {
+					return true
+				}
+				if time.Since(session.Session.LastCheckin) > ttl {
+					httpLog.Infof("Expiring idle http session %s (ttl exceeded)", session.ID)
+					core.Sessions.Remove(session.Session.ID)
+					store.Remove(session.ID)
+				}
+				return true
+			})
+		}
+	}
+}
+
+func newHTTPSession() *HTTPSession {
+	return &HTTPSession{
+		ID:      newHTTPSessionID(),
+		Started: time.Now(),
+	}
+}
+
+// rehydrateSession - Reconstructs a core.Session from the metadata persisted
+// alongside an HTTPSession so it can be re-registered with core.Sessions after
+// a listener restart, without requiring the implant to re-key. The ID set
+// here is only a placeholder: rehydrateSessions (sessions_bolt.go) overwrites
+// it with a fresh core.NextSessionID() before the session ever reaches
+// core.Sessions, since replaying the pre-restart ID verbatim risks colliding
+// with one core hands to a freshly-checked-in implant after restart.
+func rehydrateSession(meta *persistedSessionMeta) *core.Session {
+	session := &core.Session{
+		ID:            meta.SessionID,
+		Transport:     meta.Transport,
+		RemoteAddress: meta.RemoteAddress,
+		Send:          make(chan *sliverpb.Envelope),
+		RespMutex:     &sync.RWMutex{},
+		Resp:          map[uint64]chan *sliverpb.Envelope{},
+	}
+	session.LastCheckin = meta.LastCheckin
+	return session
+}
+
+// persistedSessionMeta - The subset of core.Session fields needed to rehydrate a
+// session after restart, plus the negotiated key/replay ring for the HTTPSession.
+type persistedSessionMeta struct {
+	SchemaVersion int
+
+	SessionID     uint32
+	Transport     string
+	RemoteAddress string
+	LastCheckin   time.Time
+
+	HTTPSessionID string
+	Key           []byte
+	Started       time.Time
+	ReplayRing    []string
+}
@@ -0,0 +1,300 @@
+package c2
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bishopfox/sliver/server/assets"
+	"github.com/bishopfox/sliver/server/core"
+	"github.com/bishopfox/sliver/server/cryptography"
+
+	"go.etcd.io/bbolt"
+)
+
+// CurrentSessionSchemaVersion - Bumped whenever persistedSessionMeta's on-disk
+// shape changes. The version is stored in each record; loadCache skips any
+// record written by a newer schema, while the bucket itself is reused as is.
+const CurrentSessionSchemaVersion = 1
+
+const (
+	httpSessionsDBFile = "http-sessions.db"
+	httpSessionsBucket = "http-sessions"
+	boltOpenTimeout    = 5 * time.Second
+
+	// replayFlushInterval - How often flushDirtySessions writes the replay rings
+	// RecordReplay has touched since the last sweep, instead of a Bolt transaction
+	// on every single polled message.
+	replayFlushInterval = 2 * time.Second
+)
+
+// boltSessionStore - BoltDB-backed SessionStore, rooted under the server data
+// dir, so HTTPSession state (negotiated key, replay ring, session metadata)
+// survives a listener restart or crash.
+type boltSessionStore struct {
+	db    *bbolt.DB
+	mutex sync.Mutex
+
+	// cache - Bolt only stores the persisted metadata; the live *core.Session
+	// (channels, mutexes) is kept in-memory and rebuilt once at startup.
+	cache map[string]*HTTPSession
+
+	// dirty - Session IDs RecordReplay has touched since the last flushDirtySessions
+	// sweep; batches replay-ring writes instead of a Bolt transaction per message.
+	dirtyMutex sync.Mutex
+	dirty      map[string]bool
+}
+
+func defaultSessionDBPath() string {
+	return filepath.Join(assets.GetRootAppDir(), "c2", httpSessionsDBFile)
+}
+
+func newBoltSessionStore(dbPath string) (*boltSessionStore, error) {
+	if dbPath == "" {
+		dbPath = defaultSessionDBPath()
+	}
+	db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open http session store %s: %w", dbPath, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(httpSessionsBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store := &boltSessionStore{db: db, cache: map[string]*HTTPSession{}, dirty: map[string]bool{}}
+	if err := store.loadCache(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// loadCache - Reads every persisted session into memory once at startup,
+// skipping (and dropping) entries whose schema version is ahead of what this
+// binary understands.
+func (s *boltSessionStore) loadCache() error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(httpSessionsBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			meta := &persistedSessionMeta{}
+			if err := json.Unmarshal(value, meta); err != nil {
+				httpLog.Warnf("Dropping corrupt http session record %s: %s", key, err)
+				return nil
+			}
+			if meta.SchemaVersion > CurrentSessionSchemaVersion {
+				httpLog.Warnf("Skipping http session record %s written by a newer schema (v%d)", key, meta.SchemaVersion)
+				return nil
+			}
+			httpSession := &HTTPSession{
+				ID:      meta.HTTPSessionID,
+				Started: meta.Started,
+			}
+			httpSession.Key, _ = cryptography.AESKeyFromBytes(meta.Key)
+			httpSession.replayRing = append([]string{}, meta.ReplayRing...)
+			httpSession.replaySeen = map[string]bool{}
+			for _, digest := range httpSession.replayRing {
+				httpSession.replaySeen[digest] = true
+			}
+			httpSession.Session = rehydrateSession(meta)
+			s.cache[httpSession.ID] = httpSession
+			return nil
+		})
+	})
+}
+
+func (s *boltSessionStore) persist(session *HTTPSession) error {
+	meta := &persistedSessionMeta{
+		SchemaVersion: CurrentSessionSchemaVersion,
+		HTTPSessionID: session.ID,
+		Key:           session.Key[:],
+		Started:       session.Started,
+		ReplayRing:    session.replayRing,
+	}
+	if session.Session != nil {
+		meta.SessionID = session.Session.ID
+		meta.Transport = session.Session.Transport
+		meta.RemoteAddress = session.Session.RemoteAddress
+		meta.LastCheckin = session.Session.LastCheckin
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(httpSessionsBucket))
+		return bucket.Put([]byte(session.ID), data)
+	})
+}
+
+// Add - Add (and persist) an HTTP session
+func (s *boltSessionStore) Add(session *HTTPSession) {
+	s.mutex.Lock()
+	s.cache[session.ID] = session
+	s.mutex.Unlock()
+	if err := s.persist(session); err != nil {
+		httpLog.Errorf("Failed to persist http session %s: %s", session.ID, err)
+	}
+}
+
+// Get - Get an HTTP session
+func (s *boltSessionStore) Get(sessionID string) *HTTPSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cache[sessionID]
+}
+
+// Remove - Remove an HTTP session
+func (s *boltSessionStore) Remove(sessionID string) {
+	s.mutex.Lock()
+	delete(s.cache, sessionID)
+	s.mutex.Unlock()
+	s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(httpSessionsBucket))
+		return bucket.Delete([]byte(sessionID))
+	})
+}
+
+// List - Snapshot of all currently held sessions
+func (s *boltSessionStore) List() []*HTTPSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sessions := make([]*HTTPSession, 0, len(s.cache))
+	for _, session := range s.cache {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Range - Iterate over all sessions, stops early if fn returns false
+func (s *boltSessionStore) Range(fn func(session *HTTPSession) bool) {
+	for _, session := range s.List() {
+		if !fn(session) {
+			return
+		}
+	}
+}
+
+// RecordReplay - Records the digest in-memory and marks the session dirty;
+// flushDirtySessions batches the actual Bolt write on its next sweep rather
+// than paying a disk-synced transaction for every single polled message.
+func (s *boltSessionStore) RecordReplay(sessionID string, digest string) bool {
+	session := s.Get(sessionID)
+	if session == nil {
+		return false
+	}
+	isReplay := session.isReplay(digest)
+	s.dirtyMutex.Lock()
+	s.dirty[sessionID] = true
+	s.dirtyMutex.Unlock()
+	return isReplay
+}
+
+// flushDirty - Persists every session RecordReplay has touched since the last
+// sweep in a single Bolt transaction.
+func (s *boltSessionStore) flushDirty() {
+	s.dirtyMutex.Lock()
+	if len(s.dirty) == 0 {
+		s.dirtyMutex.Unlock()
+		return
+	}
+	pending := s.dirty
+	s.dirty = map[string]bool{}
+	s.dirtyMutex.Unlock()
+
+	for sessionID := range pending {
+		session := s.Get(sessionID)
+		if session == nil {
+			continue
+		}
+		if err := s.persist(session); err != nil {
+			httpLog.Warnf("Failed to persist replay ring for %s: %s", sessionID, err)
+		}
+	}
+}
+
+// flushDirtySessions - Background sweep that batches RecordReplay's replay-ring
+// writes; stops when stop is closed, flushing one last time so a clean shutdown
+// never drops the most recent batch.
+func flushDirtySessions(store *boltSessionStore, stop <-chan struct{}) {
+	ticker := time.NewTicker(replayFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			store.flushDirty()
+			return
+		case <-ticker.C:
+			store.flushDirty()
+		}
+	}
+}
+
+// Close - Flushes any pending replay-ring writes and closes the underlying
+// BoltDB handle.
+func (s *boltSessionStore) Close() error {
+	s.flushDirty()
+	return s.db.Close()
+}
+
+// newSessionStore - Picks the in-memory or BoltDB-backed SessionStore depending
+// on HTTPServerConfig.PersistSessions.
+func newSessionStore(conf *HTTPServerConfig) (SessionStore, error) {
+	if !conf.PersistSessions {
+		return newMemorySessionStore(), nil
+	}
+	return newBoltSessionStore("")
+}
+
+// rehydrateSessions - On startup, re-registers every persisted session's
+// reconstructed core.Session with core.Sessions so polling implants resume
+// without re-keying via startSessionHandler. Each session is handed a fresh
+// ID from core.NextSessionID() rather than replaying the one it persisted
+// before restart: core's counter is the only thing that knows which IDs are
+// still live, so asking it again is the only way to guarantee a rehydrated
+// session can't collide with one a freshly-checked-in implant is handed
+// moments later. The new ID is persisted immediately so a second restart
+// rehydrates the same session once, not under two different identities.
+func rehydrateSessions(server *SliverHTTPC2) {
+	store, _ := server.HTTPSessions.(*boltSessionStore)
+	server.HTTPSessions.Range(func(session *HTTPSession) bool {
+		if session.Session == nil {
+			return true
+		}
+		session.Session.ID = core.NextSessionID()
+		if store != nil {
+			if err := store.persist(session); err != nil {
+				httpLog.Warnf("Failed to persist rehydrated session %s: %s", session.ID, err)
+			}
+		}
+		core.Sessions.Add(session.Session)
+		httpLog.Infof("Rehydrated http session %s (core session %d)", session.ID, session.Session.ID)
+		return true
+	})
+}
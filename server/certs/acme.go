@@ -0,0 +1,313 @@
+package certs
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/bishopfox/sliver/server/assets"
+	"github.com/bishopfox/sliver/server/log"
+)
+
+var acmeLog = log.NamedLogger("certs", "acme")
+
+// ACMEChallengeType - Which ACME challenge the manager should solve to prove
+// domain ownership before Let's Encrypt issues a certificate.
+type ACMEChallengeType string
+
+const (
+	// ACMEHTTP01 - Serve a token at http://<domain>/.well-known/acme-challenge/<token>
+	ACMEHTTP01 ACMEChallengeType = "http-01"
+	// ACMEDNS01 - Publish a TXT record at _acme-challenge.<domain>
+	ACMEDNS01 ACMEChallengeType = "dns-01"
+	// ACMETLSALPN01 - Answer the challenge directly in the TLS handshake, no extra port needed
+	ACMETLSALPN01 ACMEChallengeType = "tls-alpn-01"
+)
+
+const (
+	acmeDNSPropagationTimeout = 2 * time.Minute
+	acmeRenewBefore           = 30 * 24 * time.Hour // rotate 30 days before expiry
+	acmeRenewCheckInterval    = 12 * time.Hour
+)
+
+// ACMEChallengeSolver - Publishes and cleans up the TXT record that proves
+// control of the domain for the dns-01 challenge. certs.GetACMEManager
+// consumes one of these when ACMEChallengeType is dns-01.
+type ACMEChallengeSolver interface {
+	// Present - Publish value at _acme-challenge.<domain>
+	Present(ctx context.Context, domain string, value string) error
+	// Wait - Block until value is observable in DNS at _acme-challenge.<domain>
+	Wait(ctx context.Context, domain string, value string) error
+	// CleanUp - Remove whatever Present published
+	CleanUp(ctx context.Context, domain string, value string) error
+}
+
+// DNSProviderConfig - Selects a DNS-01 provider and its credentials. Credentials
+// are free-form so each provider can pick the keys it needs (access key/secret,
+// API token, nameserver/key for RFC 2136, ...) without a field explosion here.
+type DNSProviderConfig struct {
+	Provider    string // "route53" | "cloudflare" | "digitalocean" | "rfc2136"
+	Credentials map[string]string
+}
+
+// ACMEManager - For http-01 and tls-alpn-01, thin wrapper around autocert.Manager
+// (which implements both natively). For dns-01, which autocert cannot do, drives
+// the order against the ACME directory directly using the solver to publish the
+// DNS-01 TXT record, and shares autocert's on-disk cert cache so the cache path
+// (and therefore renewal behavior) is identical across restarts regardless of
+// which challenge type issued the certificate.
+type ACMEManager struct {
+	autocert *autocert.Manager
+	domain   string
+
+	challengeType ACMEChallengeType
+	solver        ACMEChallengeSolver
+	acmeClient    *acme.Client
+}
+
+// HTTPHandler - Serves the http-01 challenge response; a harmless pass-through
+// of fallback for every other request, same contract as autocert.Manager.
+func (m *ACMEManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.autocert.HTTPHandler(fallback)
+}
+
+// GetCertificate - TLS ClientHello certificate callback. For dns-01 the cache is
+// populated by the background renewer rather than on-demand, so this simply
+// reads whatever autocert's cache already holds for the domain.
+func (m *ACMEManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.autocert.GetCertificate(hello)
+}
+
+// GetACMEManager - Builds the ACME manager for domain according to challengeType.
+// http-01 and tls-alpn-01 are handled entirely by autocert.Manager. dns-01 drives
+// the ACME order by hand against dnsProvider so the listener never needs port 80.
+func GetACMEManager(domain string, challengeType ACMEChallengeType, dnsProvider DNSProviderConfig) (*ACMEManager, error) {
+	if challengeType == "" {
+		challengeType = ACMEHTTP01
+	}
+	cacheDir := filepath.Join(assets.GetRootAppDir(), "certs", "acme")
+	manager := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		HostPolicy:  autocert.HostWhitelist(domain),
+		Cache:       autocert.DirCache(cacheDir),
+		RenewBefore: acmeRenewBefore,
+	}
+	acmeManager := &ACMEManager{
+		autocert:      manager,
+		domain:        domain,
+		challengeType: challengeType,
+	}
+	if challengeType != ACMEDNS01 {
+		return acmeManager, nil
+	}
+
+	solver, err := newDNSSolver(dnsProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize DNS-01 provider %s: %w", dnsProvider.Provider, err)
+	}
+	acmeManager.solver = solver
+
+	if !acmeManager.needsRenewal() {
+		acmeLog.Infof("Using cached dns-01 certificate for %s, skipping issuance", domain)
+		go acmeManager.renewDNS01Loop()
+		return acmeManager, nil
+	}
+
+	if err := acmeManager.ensureACMEClient(); err != nil {
+		return nil, err
+	}
+	if err := acmeManager.obtainDNS01Certificate(context.Background()); err != nil {
+		return nil, fmt.Errorf("initial dns-01 issuance for %s failed: %w", domain, err)
+	}
+	go acmeManager.renewDNS01Loop()
+	return acmeManager, nil
+}
+
+// ensureACMEClient - Lazily generates an account key and registers it with the
+// ACME directory the first time a dns-01 issuance/renewal is actually about to
+// happen, so a restart with a still-valid cached cert never touches Let's
+// Encrypt's account/order rate limits at all.
+func (m *ACMEManager) ensureACMEClient() error {
+	if m.acmeClient != nil {
+		return nil
+	}
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate ACME account key: %w", err)
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: acme.LetsEncryptURL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), acmeDNSPropagationTimeout)
+	defer cancel()
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil {
+		return fmt.Errorf("failed to register ACME account for dns-01: %w", err)
+	}
+	m.acmeClient = client
+	return nil
+}
+
+// needsRenewal - True if there's no cached cert yet, or the cached one is
+// within acmeRenewBefore of expiring. Shared by the initial GetACMEManager
+// issuance and renewDNS01Loop so a restart with a still-valid cert never
+// re-issues.
+func (m *ACMEManager) needsRenewal() bool {
+	cert, err := m.cachedCertificate()
+	return err != nil || time.Until(cert.Leaf.NotAfter) <= acmeRenewBefore
+}
+
+// renewDNS01Loop - Background renewer; autocert's own RenewBefore handles
+// http-01/tls-alpn-01 since those certs stay entirely under its Cache.
+func (m *ACMEManager) renewDNS01Loop() {
+	ticker := time.NewTicker(acmeRenewCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !m.needsRenewal() {
+			continue
+		}
+		acmeLog.Infof("Renewing dns-01 certificate for %s", m.domain)
+		if err := m.ensureACMEClient(); err != nil {
+			acmeLog.Errorf("dns-01 renewal for %s failed: %s", m.domain, err)
+			continue
+		}
+		if err := m.obtainDNS01Certificate(context.Background()); err != nil {
+			acmeLog.Errorf("dns-01 renewal for %s failed: %s", m.domain, err)
+		}
+	}
+}
+
+func (m *ACMEManager) cachedCertificate() (*tls.Certificate, error) {
+	data, err := m.autocert.Cache.Get(context.Background(), m.domain)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return &cert, err
+}
+
+// obtainDNS01Certificate - Authorizes the order, publishes the _acme-challenge
+// TXT record via the configured provider, polls for propagation, finalizes the
+// order, and stores the resulting certificate in autocert's shared cache.
+func (m *ACMEManager) obtainDNS01Certificate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, acmeDNSPropagationTimeout)
+	defer cancel()
+
+	order, err := m.acmeClient.AuthorizeOrder(ctx, acme.DomainIDs(m.domain))
+	if err != nil {
+		return fmt.Errorf("failed to authorize order: %w", err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := m.acmeClient.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch authorization: %w", err)
+		}
+		challenge := pickChallenge(authz, "dns-01")
+		if challenge == nil {
+			return fmt.Errorf("CA did not offer a dns-01 challenge for %s", m.domain)
+		}
+
+		value, err := m.acmeClient.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return fmt.Errorf("failed to compute dns-01 record: %w", err)
+		}
+		if err := m.solver.Present(ctx, m.domain, value); err != nil {
+			return fmt.Errorf("failed to publish _acme-challenge.%s: %w", m.domain, err)
+		}
+		cleanup := func() {
+			if err := m.solver.CleanUp(ctx, m.domain, value); err != nil {
+				acmeLog.Warnf("Failed to clean up _acme-challenge.%s: %s", m.domain, err)
+			}
+		}
+		if err := m.solver.Wait(ctx, m.domain, value); err != nil {
+			cleanup()
+			return fmt.Errorf("DNS-01 propagation for %s timed out: %w", m.domain, err)
+		}
+		if _, err := m.acmeClient.Accept(ctx, challenge); err != nil {
+			cleanup()
+			return fmt.Errorf("CA rejected dns-01 challenge: %w", err)
+		}
+		if _, err := m.acmeClient.WaitAuthorization(ctx, authz.URI); err != nil {
+			cleanup()
+			return fmt.Errorf("authorization did not finish: %w", err)
+		}
+		cleanup()
+	}
+
+	order, err = m.acmeClient.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("order did not finish: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate leaf key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{m.domain}}, certKey)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %w", err)
+	}
+	derChain, _, err := m.acmeClient.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize order: %w", err)
+	}
+
+	pemChain := encodeCertChainPEM(derChain, certKey)
+	return m.autocert.Cache.Put(ctx, m.domain, pemChain)
+}
+
+// encodeCertChainPEM - autocert's DirCache expects the leaf key followed by
+// the full certificate chain, all PEM-encoded, concatenated in one blob.
+func encodeCertChainPEM(derChain [][]byte, key *ecdsa.PrivateKey) []byte {
+	var buf bytes.Buffer
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err == nil {
+		pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+	for _, der := range derChain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return buf.Bytes()
+}
+
+func pickChallenge(authz *acme.Authorization, challengeType string) *acme.Challenge {
+	for _, challenge := range authz.Challenges {
+		if challenge.Type == challengeType {
+			return challenge
+		}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package certs
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/digitalocean/godo"
+	"github.com/miekg/dns"
+	"golang.org/x/oauth2"
+)
+
+// dnsPropagationPoll - How often each solver's Wait implementation re-queries
+// DNS while waiting for the TXT record to show up.
+const dnsPropagationPoll = 5 * time.Second
+
+// acmeChallengeName - The standard DNS-01 record name for domain
+func acmeChallengeName(domain string) string {
+	return "_acme-challenge." + strings.TrimSuffix(domain, ".")
+}
+
+// waitForTXTRecord - Shared by every provider: polls the _acme-challenge TXT
+// record through the system resolver until value appears in it, or ctx is
+// canceled/times out. The authoritative nameservers are not queried directly,
+// so a caching resolver in between can delay when the record is seen.
+func waitForTXTRecord(ctx context.Context, domain string, value string) error {
+	name := acmeChallengeName(domain)
+	resolver := &net.Resolver{}
+	ticker := time.NewTicker(dnsPropagationPoll)
+	defer ticker.Stop()
+	for {
+		records, err := resolver.LookupTXT(ctx, name)
+		if err == nil {
+			for _, record := range records {
+				if record == value {
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to propagate: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// route53Solver - Publishes _acme-challenge TXT records via the Route53 API
+type route53Solver struct {
+	client       *route53.Client
+	hostedZoneID string
+}
+
+func newRoute53Solver(creds map[string]string) (*route53Solver, error) {
+	if creds["access_key_id"] == "" || creds["secret_access_key"] == "" || creds["hosted_zone_id"] == "" {
+		return nil, fmt.Errorf("route53 requires access_key_id, secret_access_key and hosted_zone_id")
+	}
+	client := route53.New(route53.Options{
+		Region: "us-east-1", // Route53 is a global service; SDK still wants a region
+		Credentials: credentials.NewStaticCredentialsProvider(
+			creds["access_key_id"], creds["secret_access_key"], "",
+		),
+	})
+	return &route53Solver{client: client, hostedZoneID: creds["hosted_zone_id"]}, nil
+}
+
+func (r *route53Solver) Present(ctx context.Context, domain string, value string) error {
+	return r.changeTXT(ctx, domain, value, types.ChangeActionUpsert)
+}
+
+func (r *route53Solver) Wait(ctx context.Context, domain string, value string) error {
+	return waitForTXTRecord(ctx, domain, value)
+}
+
+func (r *route53Solver) CleanUp(ctx context.Context, domain string, value string) error {
+	return r.changeTXT(ctx, domain, value, types.ChangeActionDelete)
+}
+
+func (r *route53Solver) changeTXT(ctx context.Context, domain string, value string, action types.ChangeAction) error {
+	name := acmeChallengeName(domain)
+	acmeLog.Infof("route53: %s %s TXT record", strings.ToLower(string(action)), name)
+	_, err := r.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.hostedZoneID),
+		ChangeBatch: &types.ChangeBatch{
+			Changes: []types.Change{
+				{
+					Action: action,
+					ResourceRecordSet: &types.ResourceRecordSet{
+						Name:            aws.String(name),
+						Type:            types.RRTypeTxt,
+						TTL:             aws.Int64(60),
+						ResourceRecords: []types.ResourceRecord{{Value: aws.String(`"` + value + `"`)}},
+					},
+				},
+			},
+		},
+	})
+	return err
+}
+
+// cloudflareSolver - Publishes _acme-challenge TXT records via the Cloudflare API
+type cloudflareSolver struct {
+	api    *cloudflare.API
+	zoneID string
+}
+
+func newCloudflareSolver(creds map[string]string) (*cloudflareSolver, error) {
+	if creds["api_token"] == "" || creds["zone_id"] == "" {
+		return nil, fmt.Errorf("cloudflare requires api_token and zone_id")
+	}
+	api, err := cloudflare.NewWithAPIToken(creds["api_token"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cloudflare client: %w", err)
+	}
+	return &cloudflareSolver{api: api, zoneID: creds["zone_id"]}, nil
+}
+
+func (c *cloudflareSolver) Present(ctx context.Context, domain string, value string) error {
+	name := acmeChallengeName(domain)
+	acmeLog.Infof("cloudflare: publishing %s TXT record", name)
+	_, err := c.api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(c.zoneID), cloudflare.CreateDNSRecordParams{
+		Type:    "TXT",
+		Name:    name,
+		Content: value,
+		TTL:     60,
+	})
+	return err
+}
+
+func (c *cloudflareSolver) Wait(ctx context.Context, domain string, value string) error {
+	return waitForTXTRecord(ctx, domain, value)
+}
+
+func (c *cloudflareSolver) CleanUp(ctx context.Context, domain string, value string) error {
+	name := acmeChallengeName(domain)
+	records, _, err := c.api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(c.zoneID), cloudflare.ListDNSRecordsParams{
+		Type: "TXT", Name: name, Content: value,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s TXT records: %w", name, err)
+	}
+	for _, record := range records {
+		acmeLog.Infof("cloudflare: removing %s TXT record %s", name, record.ID)
+		if err := c.api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(c.zoneID), record.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// digitalOceanSolver - Publishes _acme-challenge TXT records via the DigitalOcean API
+type digitalOceanSolver struct {
+	client *godo.Client
+	zone   string // apex domain registered in DigitalOcean, e.g. "example.com"
+}
+
+func newDigitalOceanSolver(creds map[string]string) (*digitalOceanSolver, error) {
+	if creds["api_token"] == "" || creds["domain"] == "" {
+		return nil, fmt.Errorf("digitalocean requires api_token and domain")
+	}
+	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: creds["api_token"]})
+	client := godo.NewClient(oauth2.NewClient(context.Background(), tokenSource))
+	return &digitalOceanSolver{client: client, zone: creds["domain"]}, nil
+}
+
+// recordName - DigitalOcean wants the record name relative to d.zone, not the FQDN
+func (d *digitalOceanSolver) recordName(domain string) string {
+	name := acmeChallengeName(domain)
+	return strings.TrimSuffix(strings.TrimSuffix(name, d.zone), ".")
+}
+
+func (d *digitalOceanSolver) Present(ctx context.Context, domain string, value string) error {
+	acmeLog.Infof("digitalocean: publishing %s TXT record in zone %s", d.recordName(domain), d.zone)
+	_, _, err := d.client.Domains.CreateRecord(ctx, d.zone, &godo.DomainRecordEditRequest{
+		Type: "TXT",
+		Name: d.recordName(domain),
+		Data: value,
+		TTL:  60,
+	})
+	return err
+}
+
+func (d *digitalOceanSolver) Wait(ctx context.Context, domain string, value string) error {
+	return waitForTXTRecord(ctx, domain, value)
+}
+
+func (d *digitalOceanSolver) CleanUp(ctx context.Context, domain string, value string) error {
+	// Unlike CreateRecord, the API's name filter takes the fully qualified name
+	records, _, err := d.client.Domains.RecordsByTypeAndName(ctx, d.zone, "TXT", acmeChallengeName(domain), nil)
+	if err != nil {
+		return fmt.Errorf("failed to list records for zone %s: %w", d.zone, err)
+	}
+	for _, record := range records {
+		if record.Data != value {
+			continue
+		}
+		acmeLog.Infof("digitalocean: removing record %d from zone %s", record.ID, d.zone)
+		if _, err := d.client.Domains.DeleteRecord(ctx, d.zone, record.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rfc2136Solver - Publishes _acme-challenge TXT records via RFC 2136 dynamic DNS
+// updates, for operators running their own authoritative nameserver (e.g. BIND).
+type rfc2136Solver struct {
+	nameserver string // host:port of the authoritative server accepting updates
+	tsigKey    string
+	tsigSecret string
+}
+
+func newRFC2136Solver(creds map[string]string) (*rfc2136Solver, error) {
+	if creds["nameserver"] == "" || creds["tsig_key"] == "" || creds["tsig_secret"] == "" {
+		return nil, fmt.Errorf("rfc2136 requires nameserver, tsig_key and tsig_secret")
+	}
+	return &rfc2136Solver{
+		nameserver: creds["nameserver"],
+		tsigKey:    creds["tsig_key"],
+		tsigSecret: creds["tsig_secret"],
+	}, nil
+}
+
+func (r *rfc2136Solver) Present(ctx context.Context, domain string, value string) error {
+	return r.update(domain, value, false)
+}
+
+func (r *rfc2136Solver) Wait(ctx context.Context, domain string, value string) error {
+	return waitForTXTRecord(ctx, domain, value)
+}
+
+func (r *rfc2136Solver) CleanUp(ctx context.Context, domain string, value string) error {
+	return r.update(domain, value, true)
+}
+
+// update - Sends a TSIG-signed dns.Msg UPDATE to r.nameserver, inserting or
+// removing the _acme-challenge TXT record depending on remove.
+func (r *rfc2136Solver) update(domain string, value string, remove bool) error {
+	fqdn := dns.Fqdn(acmeChallengeName(domain))
+	rr, err := dns.NewRR(fmt.Sprintf("%s 60 IN TXT %q", fqdn, value))
+	if err != nil {
+		return fmt.Errorf("failed to build TXT RR for %s: %w", fqdn, err)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(domain))
+	if remove {
+		acmeLog.Infof("rfc2136: removing %s TXT record via %s", fqdn, r.nameserver)
+		msg.Remove([]dns.RR{rr})
+	} else {
+		acmeLog.Infof("rfc2136: publishing %s TXT record via %s", fqdn, r.nameserver)
+		msg.Insert([]dns.RR{rr})
+	}
+	msg.SetTsig(dns.Fqdn(r.tsigKey), dns.HmacSHA256, 300, time.Now().Unix())
+
+	client := new(dns.Client)
+	client.TsigSecret = map[string]string{dns.Fqdn(r.tsigKey): r.tsigSecret}
+	resp, _, err := client.Exchange(msg, r.nameserver)
+	if err != nil {
+		return fmt.Errorf("rfc2136 update to %s failed: %w", r.nameserver, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rfc2136 update to %s rejected: %s", r.nameserver, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// newDNSSolver - Resolves the configured DNSProviderConfig to a concrete solver
+func newDNSSolver(conf DNSProviderConfig) (ACMEChallengeSolver, error) {
+	switch conf.Provider {
+	case "route53":
+		return newRoute53Solver(conf.Credentials)
+	case "cloudflare":
+		return newCloudflareSolver(conf.Credentials)
+	case "digitalocean":
+		return newDigitalOceanSolver(conf.Credentials)
+	case "rfc2136":
+		return newRFC2136Solver(conf.Credentials)
+	default:
+		return nil, fmt.Errorf("unknown DNS-01 provider %q", conf.Provider)
+	}
+}
@@ -0,0 +1,75 @@
+package certs
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import "testing"
+
+func TestNewDNSSolverRejectsIncompleteCredentials(t *testing.T) {
+	tests := []struct {
+		name  string
+		conf  DNSProviderConfig
+		valid bool
+	}{
+		{"route53 missing hosted_zone_id", DNSProviderConfig{Provider: "route53", Credentials: map[string]string{
+			"access_key_id": "AKIA", "secret_access_key": "secret",
+		}}, false},
+		{"route53 complete", DNSProviderConfig{Provider: "route53", Credentials: map[string]string{
+			"access_key_id": "AKIA", "secret_access_key": "secret", "hosted_zone_id": "Z123",
+		}}, true},
+		{"cloudflare missing zone_id", DNSProviderConfig{Provider: "cloudflare", Credentials: map[string]string{
+			"api_token": "token",
+		}}, false},
+		{"cloudflare complete", DNSProviderConfig{Provider: "cloudflare", Credentials: map[string]string{
+			"api_token": "token", "zone_id": "zone",
+		}}, true},
+		{"digitalocean missing domain", DNSProviderConfig{Provider: "digitalocean", Credentials: map[string]string{
+			"api_token": "token",
+		}}, false},
+		{"digitalocean complete", DNSProviderConfig{Provider: "digitalocean", Credentials: map[string]string{
+			"api_token": "token", "domain": "example.com",
+		}}, true},
+		{"rfc2136 missing tsig_secret", DNSProviderConfig{Provider: "rfc2136", Credentials: map[string]string{
+			"nameserver": "ns1.example.com:53", "tsig_key": "key",
+		}}, false},
+		{"rfc2136 complete", DNSProviderConfig{Provider: "rfc2136", Credentials: map[string]string{
+			"nameserver": "ns1.example.com:53", "tsig_key": "key", "tsig_secret": "secret",
+		}}, true},
+		{"unknown provider", DNSProviderConfig{Provider: "bogus"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newDNSSolver(test.conf)
+			if test.valid && err != nil {
+				t.Fatalf("newDNSSolver(%+v) returned unexpected error: %s", test.conf, err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("newDNSSolver(%+v) should have rejected incomplete/unknown config", test.conf)
+			}
+		})
+	}
+}
+
+func TestAcmeChallengeName(t *testing.T) {
+	if got, want := acmeChallengeName("example.com"), "_acme-challenge.example.com"; got != want {
+		t.Fatalf("acmeChallengeName(\"example.com\") = %q, want %q", got, want)
+	}
+	if got, want := acmeChallengeName("example.com."), "_acme-challenge.example.com"; got != want {
+		t.Fatalf("acmeChallengeName(\"example.com.\") = %q, want %q", got, want)
+	}
+}
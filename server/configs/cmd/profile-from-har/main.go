@@ -0,0 +1,70 @@
+package main
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// profile-from-har - Clones the response fingerprint of a real website from a
+// browser-exported HAR capture into a configs.TrafficProfile, so it can be
+// dropped into http-c2.json's TrafficProfiles list. Thin CLI wrapper around
+// configs.GenerateProfileFromHAR; see that function for the HAR subset read.
+//
+// Usage:
+//
+//	profile-from-har -har site.har -name my-profile > profile.json
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bishopfox/sliver/server/configs"
+)
+
+func main() {
+	harPath := flag.String("har", "", "path to a browser-exported HAR file (required)")
+	profileName := flag.String("name", "", "name to give the generated TrafficProfile (required)")
+	outPath := flag.String("out", "", "write the profile JSON here instead of stdout")
+	flag.Parse()
+
+	if *harPath == "" || *profileName == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	profile, err := configs.GenerateProfileFromHAR(*harPath, *profileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate profile: %s\n", err)
+		os.Exit(1)
+	}
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode profile: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *outPath == "" {
+		fmt.Println(string(data))
+		return
+	}
+	if err := os.WriteFile(*outPath, data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %s\n", *outPath, err)
+		os.Exit(1)
+	}
+}
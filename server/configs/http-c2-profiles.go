@@ -0,0 +1,195 @@
+package configs
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// builtinTrafficProfiles - Shipped defaults, cloning the response shape of a
+// few common origins. Operators can add their own via GenerateProfileFromHAR
+// and drop the result into http-c2.json's TrafficProfiles list.
+var builtinTrafficProfiles = []*TrafficProfile{
+	nginxUbuntuProfile,
+	apacheCentOSProfile,
+	iisAspNetProfile,
+	cloudflareProfile,
+}
+
+var nginxUbuntuProfile = &TrafficProfile{
+	Name: "nginx-ubuntu",
+	Headers: []HeaderTemplate{
+		{Name: "Server", Value: "nginx/1.18.0 (Ubuntu)"},
+		{Name: "X-Powered-By", Value: ""}, // nginx doesn't set this by default
+		{Name: "Cache-Control", Value: "no-store, no-cache, must-revalidate"},
+	},
+	Cookie: CookieTemplate{Name: "SID", HttpOnly: true, Secure: true, SameSite: "Lax"},
+	ContentTypes: map[string]string{
+		".txt": "text/plain; charset=utf-8",
+		".css": "text/css; charset=utf-8",
+		".php": "text/html; charset=utf-8",
+		".js":  "application/javascript; charset=utf-8",
+		".png": "image/png",
+	},
+	ChunkedTransfer: true,
+	Padding:         PaddingProfile{MinBytes: 0, MaxBytes: 512, Envelope: "raw"},
+}
+
+var apacheCentOSProfile = &TrafficProfile{
+	Name: "apache-centos",
+	Headers: []HeaderTemplate{
+		{Name: "Server", Value: "Apache/2.4.37 (CentOS)"},
+		{Name: "X-Powered-By", Value: "PHP/7.2.24"},
+		{Name: "Cache-Control", Value: "no-store, no-cache, must-revalidate"},
+	},
+	Cookie: CookieTemplate{Name: "PHPSESSID", HttpOnly: true, Secure: true, SameSite: ""},
+	ContentTypes: map[string]string{
+		".txt": "text/plain; charset=UTF-8",
+		".css": "text/css",
+		".php": "text/html; charset=UTF-8",
+		".js":  "text/javascript; charset=UTF-8",
+		".png": "image/png",
+	},
+	ChunkedTransfer: false,
+	Padding:         PaddingProfile{MinBytes: 64, MaxBytes: 1024, Envelope: "html"},
+}
+
+var iisAspNetProfile = &TrafficProfile{
+	Name: "iis-aspnet",
+	Headers: []HeaderTemplate{
+		{Name: "Server", Value: "Microsoft-IIS/10.0"},
+		{Name: "X-Powered-By", Value: "ASP.NET"},
+		{Name: "X-AspNet-Version", Value: "4.0.30319"},
+		{Name: "Cache-Control", Value: "private"},
+	},
+	Cookie: CookieTemplate{Name: "ASP.NET_SessionId", HttpOnly: true, Secure: true, SameSite: "Lax"},
+	ContentTypes: map[string]string{
+		".txt": "text/plain",
+		".css": "text/css",
+		".php": "text/html",
+		".js":  "application/x-javascript",
+		".png": "image/png",
+	},
+	ChunkedTransfer: false,
+	Padding:         PaddingProfile{MinBytes: 0, MaxBytes: 256, Envelope: "json"},
+}
+
+var cloudflareProfile = &TrafficProfile{
+	Name: "cloudflare-fronted",
+	Headers: []HeaderTemplate{
+		{Name: "Server", Value: "cloudflare"},
+		{Name: "CF-RAY", Value: "7c1a9e9d4c9f0000-SJC"},
+		{Name: "Cache-Control", Value: "no-store, no-cache, must-revalidate"},
+	},
+	Cookie: CookieTemplate{Name: "__cflb", HttpOnly: true, Secure: true, SameSite: "None"},
+	ContentTypes: map[string]string{
+		".txt": "text/plain; charset=UTF-8",
+		".css": "text/css; charset=UTF-8",
+		".php": "text/html; charset=UTF-8",
+		".js":  "application/javascript; charset=UTF-8",
+		".png": "image/png",
+	},
+	ChunkedTransfer: true,
+	Padding:         PaddingProfile{MinBytes: 128, MaxBytes: 2048, Envelope: "base64"},
+}
+
+// harEntry/harFile - The small slice of the HAR 1.2 schema this package reads.
+// See http://www.softwareishard.com/blog/har-12-spec/ for the full format.
+type harEntry struct {
+	Request struct {
+		URL string `json:"url"`
+	} `json:"request"`
+	Response struct {
+		Headers []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"headers"`
+		Content struct {
+			MimeType string `json:"mimeType"`
+		} `json:"content"`
+	} `json:"response"`
+}
+
+type harFile struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+// GenerateProfileFromHAR - Clones the response shape of a real website from a
+// browser-exported HAR capture, so operators can impersonate a specific host
+// instead of picking one of the built-in profiles. Backs the equivalent CLI
+// generator command; exported here so it is also usable as a library call.
+func GenerateProfileFromHAR(harPath string, profileName string) (*TrafficProfile, error) {
+	data, err := os.ReadFile(harPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HAR file %s: %w", harPath, err)
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("failed to parse HAR file %s: %w", harPath, err)
+	}
+	if len(har.Log.Entries) == 0 {
+		return nil, fmt.Errorf("HAR file %s has no captured entries", harPath)
+	}
+
+	profile := &TrafficProfile{
+		Name:         profileName,
+		ContentTypes: map[string]string{},
+		Padding:      PaddingProfile{MinBytes: 0, MaxBytes: 256, Envelope: "raw"},
+	}
+	// Use the first entry's header order/values as the template; later entries
+	// only contribute additional Content-Type mappings.
+	for _, header := range har.Log.Entries[0].Response.Headers {
+		if skipHopByHopHeader(header.Name) {
+			continue
+		}
+		profile.Headers = append(profile.Headers, HeaderTemplate{Name: header.Name, Value: header.Value})
+	}
+	for _, entry := range har.Log.Entries {
+		ext := extFromURL(entry.Request.URL)
+		if ext != "" && entry.Response.Content.MimeType != "" {
+			profile.ContentTypes[ext] = entry.Response.Content.MimeType
+		}
+	}
+	return profile, nil
+}
+
+func skipHopByHopHeader(name string) bool {
+	switch name {
+	case "Content-Length", "Transfer-Encoding", "Connection", "Date", "Set-Cookie":
+		return true
+	default:
+		return false
+	}
+}
+
+func extFromURL(rawURL string) string {
+	for i := len(rawURL) - 1; i >= 0; i-- {
+		switch rawURL[i] {
+		case '.':
+			return rawURL[i:]
+		case '/', '?':
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package configs
+
+/*
+	Sliver Implant Framework
+	Copyright (C) 2019  Bishop Fox
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bishopfox/sliver/server/assets"
+	"github.com/bishopfox/sliver/server/log"
+)
+
+var (
+	httpC2Log = log.NamedLogger("configs", "http-c2")
+
+	httpC2ConfigOnce sync.Once
+	httpC2Config     *HTTPC2Config
+)
+
+const httpC2ConfigFileName = "http-c2.json"
+
+// HeaderTemplate - One entry in a TrafficProfile's ordered response header list.
+// Value is sent verbatim; no placeholders in it are expanded. An empty Value
+// means the header is not sent at all.
+type HeaderTemplate struct {
+	Name  string
+	Value string
+}
+
+// CookieTemplate - Attributes applied to the session cookie set by startSessionHandler
+type CookieTemplate struct {
+	Name     string
+	HttpOnly bool
+	Secure   bool
+	SameSite string // "", "Lax", "Strict", "None"
+}
+
+// PaddingProfile - Body padding distribution applied to the website/404 fallback
+// handler's responses (never the procedural C2 handlers, whose bodies carry
+// ciphertext with no framing to recover padded bytes from), so static content
+// served by the listener doesn't cluster around a tell-tale fixed size either.
+type PaddingProfile struct {
+	MinBytes int
+	MaxBytes int
+	Envelope string // "raw" | "base64" | "json" | "html"
+}
+
+// TrafficProfile - A full response fingerprint cloned from (or modeled after) a
+// real origin server, so a given implant's HTTP(S) traffic looks like one
+// coherent site across every poll instead of randomizing per-response.
+type TrafficProfile struct {
+	Name string
+
+	Headers []HeaderTemplate
+	Cookie  CookieTemplate
+
+	// ContentTypes - Maps a procedural C2 extension (".txt", ".js", ...) to the
+	// Content-Type that origin actually serves it with.
+	ContentTypes map[string]string
+
+	ChunkedTransfer bool
+	Padding         PaddingProfile
+}
+
+// HTTPC2ServerConfig - Server-side knobs for the HTTP(S) C2 listener
+type HTTPC2ServerConfig struct {
+	Cookies []string
+
+	// TrafficProfile - Name of the TrafficProfile (see TrafficProfiles below) to
+	// render responses with. Empty maps each session onto one of TrafficProfiles
+	// by a hash of its session ID (remote address before a session exists).
+	TrafficProfile string
+}
+
+// HTTPC2Config - Root config consumed by server/c2, loaded once from disk
+type HTTPC2Config struct {
+	ServerConfig    HTTPC2ServerConfig
+	TrafficProfiles []*TrafficProfile
+}
+
+// GetTrafficProfile - Looks up a profile by name, falling back to the first
+// entry of TrafficProfiles if name is empty or unknown.
+func (c *HTTPC2Config) GetTrafficProfile(name string) *TrafficProfile {
+	for _, profile := range c.TrafficProfiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return c.TrafficProfiles[0]
+}
+
+// GetHTTPC2Config - Loads (and caches) the HTTP C2 config, falling back to the
+// built-in defaults when no config file is present on disk yet.
+func GetHTTPC2Config() *HTTPC2Config {
+	httpC2ConfigOnce.Do(func() {
+		httpC2Config = loadHTTPC2Config()
+	})
+	return httpC2Config
+}
+
+func loadHTTPC2Config() *HTTPC2Config {
+	config := defaultHTTPC2Config()
+	configPath := filepath.Join(assets.GetRootAppDir(), "configs", httpC2ConfigFileName)
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return config // No config on disk yet, built-in defaults apply
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		httpC2Log.Errorf("Failed to parse %s: %s", configPath, err)
+		return defaultHTTPC2Config()
+	}
+	return config
+}
+
+func defaultHTTPC2Config() *HTTPC2Config {
+	return &HTTPC2Config{
+		ServerConfig: HTTPC2ServerConfig{
+			Cookies: []string{
+				"PHPSESSID", "SID", "SSID", "APISID", "csrftoken", "session", "ASP.NET_SessionId",
+			},
+		},
+		TrafficProfiles: builtinTrafficProfiles,
+	}
+}